@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+
+	"github.com/pkg/errors"
+
+	"github.com/HanseMerkur/kubernetes-cifs-volumedriver/pkg/cifs"
+	"github.com/HanseMerkur/kubernetes-cifs-volumedriver/pkg/command"
+)
+
+const (
+	retStatSuccess             = "Success"
+	retStatFailure             = "Failure"
+	retStatNotSupported        = "Not supported"
+	retMsgInsufficientArgs     = "Insufficient arguments"
+	retMsgUnsupportedOperation = "Unsupported operation"
+
+	// reasonInsufficientArgs is the ret.Reason value for ErrInsufficientArgs;
+	// it has no cifs.Reason equivalent since argv length is a FlexVolume
+	// protocol concern, not something the CSI surface can hit.
+	reasonInsufficientArgs = "InsufficientArgs"
+	// reasonUnexpected is the ret.Reason value for the recover() backstop
+	// below, which should only fire on a genuine bug rather than a
+	// classified failure.
+	reasonUnexpected = "Unexpected"
+)
+
+const logFileName = "/var/log/kubernetes-cifs-volumedriver.log"
+
+// ErrInsufficientArgs is returned by createMountCmd/createUmountCmd, and
+// checked directly in driverMain, when the FlexVolume call has fewer argv
+// entries than the verb requires.
+var ErrInsufficientArgs = errors.New(retMsgInsufficientArgs)
+
+// returnMsg is the response given back to k8s
+type returnMsg struct {
+	Status       string
+	Message      string
+	Capabilities capabilities
+	VolumeName   string   `json:",omitempty"`
+	Attached     *bool    `json:",omitempty"`
+	Metrics      *metrics `json:",omitempty"`
+	// Reason is a machine-readable classification of a Failure, suitable
+	// for kubelet event deduplication/filtering without regexing Message.
+	// Empty on success. See reasonFor for the mapping.
+	Reason string `json:",omitempty"`
+}
+
+// Part of the repsonse that informs the driver's capabilities
+type capabilities struct {
+	Attach          bool
+	FSGroup         bool
+	SupportsMetrics bool
+
+	// TODO: Check if these capabilities make sense for this driver.
+	// SELinuxRelabel   bool
+	// RequiresFSResize bool
+}
+
+func unmarshalMounterArgs(s string) (ma cifs.MounterArgs, err error) {
+	if err = json.Unmarshal([]byte(s), &ma); err != nil {
+		return ma, errors.Wrap(err, "Error interpreting mounter args")
+	}
+	if err = ma.DecodeCredentials(); err != nil {
+		return ma, err
+	}
+	return ma, nil
+}
+
+func createMountCmd(cmdLineArgs []string, runner command.Runner) (cmd *exec.Cmd, err error) {
+	if len(cmdLineArgs) < 4 {
+		return nil, ErrInsufficientArgs
+	}
+
+	mArgs, err := unmarshalMounterArgs(cmdLineArgs[3])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cifs.PrepareKerberosAuth(runner, mArgs); err != nil {
+		return nil, err
+	}
+
+	return cifs.BuildCIFSMount(mArgs, cmdLineArgs[2])
+}
+
+func createUmountCmd(cmdLineArgs []string) (cmd *exec.Cmd, err error) {
+	if len(cmdLineArgs) < 3 {
+		return nil, ErrInsufficientArgs
+	}
+	cmd = exec.Command("umount")
+	cmd.Args = append(cmd.Args, cmdLineArgs[2])
+	return cmd, nil
+}
+
+// reasonFor classifies err into the machine-readable Reason reported on
+// returnMsg, keeping the exit-code mapping ClassifyMountError already
+// applies (13/5+nodfs/32) available without parsing Message text.
+func reasonFor(err error) string {
+	switch {
+	case errors.Is(err, ErrInsufficientArgs):
+		return reasonInsufficientArgs
+	case errors.Is(err, cifs.ErrInvalidMounterArgs):
+		return string(cifs.ReasonInvalidMounterArgs)
+	case errors.Is(err, cifs.ErrBadCredentialEncoding):
+		return string(cifs.ReasonBadCredentialEncoding)
+	}
+	var mountErr *cifs.ErrMountFailed
+	if errors.As(err, &mountErr) {
+		return string(mountErr.Reason)
+	}
+	return ""
+}
+
+// Dettach from main, allows tests to be written for this function
+func driverMain(args []string, runner command.Runner) (ret returnMsg) {
+	ret.Status = retStatSuccess
+
+	defer func() {
+		err := recover()
+		if err != nil {
+			ret.Status = retStatFailure
+			ret.Message = fmt.Sprintf("Unexpected executing volume driver: %s", err)
+			ret.Reason = reasonUnexpected
+			return
+		}
+	}()
+
+	if len(args) < 2 {
+		ret.Status = retStatFailure
+		ret.Message = retMsgInsufficientArgs
+		ret.Reason = reasonFor(ErrInsufficientArgs)
+		return
+	}
+
+	var operation = args[1]
+	switch operation {
+	case "init":
+		log.Println("Driver init")
+		ret.Status = retStatSuccess
+		ret.Capabilities.Attach = false         // this driver does not attach any devices
+		ret.Capabilities.FSGroup = false        // avoids chown/chmod upstream in driver caller
+		ret.Capabilities.SupportsMetrics = true // f_blocks*f_bsize via statfs is exactly what the SMB server reports
+	case "getvolumename":
+		if len(args) < 3 {
+			ret.Status = retStatFailure
+			ret.Message = retMsgInsufficientArgs
+			ret.Reason = reasonFor(ErrInsufficientArgs)
+			return
+		}
+		mArgs, err := unmarshalMounterArgs(args[2])
+		if err != nil {
+			ret.Status = retStatFailure
+			ret.Message = fmt.Sprintf("Error: %s", err)
+			ret.Reason = reasonFor(err)
+			return
+		}
+		ret.VolumeName = mArgs.PvName
+	case "isattached":
+		// This driver never attaches devices (Capabilities.Attach is
+		// false), so there is nothing to detect: report attached so
+		// kubelet doesn't wait on an attach that will never happen.
+		attached := true
+		ret.Attached = &attached
+	case "mountdevice", "unmountdevice":
+		// Only called for attachable volumes; this driver mounts
+		// directly via "mount"/"unmount" instead.
+		ret.Status = retStatNotSupported
+		ret.Message = retMsgUnsupportedOperation + ": " + operation
+	case "getmetrics":
+		if len(args) < 3 {
+			ret.Status = retStatFailure
+			ret.Message = retMsgInsufficientArgs
+			ret.Reason = reasonFor(ErrInsufficientArgs)
+			return
+		}
+		m, err := getMetrics(args[2])
+		if err != nil {
+			ret.Status = retStatFailure
+			ret.Message = fmt.Sprintf("Error: %s", err)
+			ret.Reason = reasonFor(err)
+			return
+		}
+		ret.Metrics = &m
+	case "mount":
+		cmd, err := createMountCmd(args, runner)
+		if err != nil {
+			ret.Status = retStatFailure
+			ret.Message = fmt.Sprintf("Error: %s", err)
+			ret.Reason = reasonFor(err)
+			return
+		}
+		log.Println(cmd.Args)
+		if err := cifs.RunMount(runner, cmd); err != nil {
+			ret.Status = retStatFailure
+			ret.Message = fmt.Sprintf("Error: %s", err)
+			ret.Reason = reasonFor(err)
+		}
+	case "unmount":
+		cmd, err := createUmountCmd(args)
+		if err != nil {
+			ret.Status = retStatFailure
+			ret.Message = fmt.Sprintf("Error: %s", err)
+			ret.Reason = reasonFor(err)
+			return
+		}
+		log.Println(cmd.Args)
+		if err := cifs.RunMount(runner, cmd); err != nil {
+			ret.Status = retStatFailure
+			ret.Message = fmt.Sprintf("Error: %s", err)
+			ret.Reason = reasonFor(err)
+		}
+	default:
+		ret.Status = retStatNotSupported
+		ret.Message = retMsgUnsupportedOperation + ": " + operation
+	}
+	return
+}
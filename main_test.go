@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/base64"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/HanseMerkur/kubernetes-cifs-volumedriver/pkg/command"
+)
+
+// exitErr runs a shell that exits with code, returning the resulting
+// *exec.ExitError so tests can script realistic command.Runner failures.
+func exitErr(t *testing.T, code int) error {
+	t.Helper()
+	err := exec.Command("sh", "-c", "exit "+strconv.Itoa(code)).Run()
+	if err == nil {
+		t.Fatalf("expected exit %d to produce an error", code)
+	}
+	return err
+}
+
+func mounterArgsJSON(server, share, domain, user, pass, opts string) string {
+	b := `{"server":"` + server + `","share":"` + share + `"`
+	if domain != "" {
+		b += `,"kubernetes.io/secret/domain":"` + base64.StdEncoding.EncodeToString([]byte(domain)) + `"`
+	}
+	if user != "" {
+		b += `,"kubernetes.io/secret/username":"` + base64.StdEncoding.EncodeToString([]byte(user)) + `"`
+	}
+	if pass != "" {
+		b += `,"kubernetes.io/secret/password":"` + base64.StdEncoding.EncodeToString([]byte(pass)) + `"`
+	}
+	if opts != "" {
+		b += `,"opts":"` + opts + `"`
+	}
+	b += `}`
+	return b
+}
+
+func TestCreateMountCmd(t *testing.T) {
+	args := []string{"driver", "mount", "/var/lib/kubelet/pods/xyz/volumes/foo", mounterArgsJSON("myserver", "/myshare", "mydomain", "myuser", "mypass", "ro")}
+
+	cmd, err := createMountCmd(args, command.NewFakeRunner(nil, nil))
+	if err != nil {
+		t.Fatalf("createMountCmd returned error: %s", err)
+	}
+
+	wantArgv := []string{"mount", "-t", "cifs", "-o", "domain=mydomain,username=myuser,ro", "//myserver/myshare", "/var/lib/kubelet/pods/xyz/volumes/foo"}
+	if strings.Join(cmd.Args, " ") != strings.Join(wantArgv, " ") {
+		t.Fatalf("unexpected argv: got %v, want %v", cmd.Args, wantArgv)
+	}
+
+	var gotPasswd string
+	for _, e := range cmd.Env {
+		if strings.HasPrefix(e, "PASSWD=") {
+			gotPasswd = e
+		}
+	}
+	if gotPasswd != "PASSWD=mypass" {
+		t.Fatalf("unexpected PASSWD env entry: %q", gotPasswd)
+	}
+}
+
+func TestCreateMountCmdInsufficientArgs(t *testing.T) {
+	if _, err := createMountCmd([]string{"driver", "mount"}, command.NewFakeRunner(nil, nil)); err == nil {
+		t.Fatal("expected error for insufficient args, got nil")
+	}
+}
+
+func TestCreateMountCmdInvalidMounterArgs(t *testing.T) {
+	args := []string{"driver", "mount", "/mnt/foo", `{}`}
+	if _, err := createMountCmd(args, command.NewFakeRunner(nil, nil)); err == nil {
+		t.Fatal("expected error when neither server/share nor source is set, got nil")
+	}
+}
+
+func TestCreateMountCmdKrb5MissingCredentials(t *testing.T) {
+	args := []string{"driver", "mount", "/mnt/foo", `{"server":"myserver","share":"/myshare","passwdMethod":"krb5"}`}
+	if _, err := createMountCmd(args, command.NewFakeRunner(nil, nil)); err == nil {
+		t.Fatal("expected error when krb5 auth is missing keytab/principal, got nil")
+	}
+}
+
+func TestCreateMountCmdMultiuser(t *testing.T) {
+	args := []string{"driver", "mount", "/mnt/foo", `{"server":"myserver","share":"/myshare","passwdMethod":"multiuser"}`}
+	cmd, err := createMountCmd(args, command.NewFakeRunner(nil, nil))
+	if err != nil {
+		t.Fatalf("createMountCmd returned error: %s", err)
+	}
+	wantArgv := []string{"mount", "-t", "cifs", "-o", "sec=ntlmssp,multiuser", "//myserver/myshare", "/mnt/foo"}
+	if strings.Join(cmd.Args, " ") != strings.Join(wantArgv, " ") {
+		t.Fatalf("unexpected argv: got %v, want %v", cmd.Args, wantArgv)
+	}
+}
+
+func TestDriverMainGetVolumeName(t *testing.T) {
+	ret := driverMain([]string{"driver", "getvolumename", `{"kubernetes.io/pvOrVolumeName":"my-pv"}`}, command.NewFakeRunner(nil, nil))
+	if ret.Status != retStatSuccess || ret.VolumeName != "my-pv" {
+		t.Fatalf("unexpected result: %+v", ret)
+	}
+}
+
+func TestDriverMainIsAttached(t *testing.T) {
+	ret := driverMain([]string{"driver", "isattached", `{}`, "some-node"}, command.NewFakeRunner(nil, nil))
+	if ret.Status != retStatSuccess || ret.Attached == nil || !*ret.Attached {
+		t.Fatalf("unexpected result: %+v", ret)
+	}
+}
+
+func TestDriverMainReasonInsufficientArgs(t *testing.T) {
+	ret := driverMain([]string{"driver"}, command.NewFakeRunner(nil, nil))
+	if ret.Status != retStatFailure || ret.Reason != reasonInsufficientArgs {
+		t.Fatalf("unexpected result: %+v", ret)
+	}
+}
+
+func TestDriverMainReasonInvalidMounterArgs(t *testing.T) {
+	args := []string{"driver", "mount", "/mnt/foo", `{}`}
+	ret := driverMain(args, command.NewFakeRunner(nil, nil))
+	if ret.Status != retStatFailure || ret.Reason != "InvalidMounterArgs" {
+		t.Fatalf("unexpected result: %+v", ret)
+	}
+}
+
+func TestDriverMainReasonMountFailed(t *testing.T) {
+	args := []string{"driver", "mount", "/mnt/foo", mounterArgsJSON("myserver", "/myshare", "", "", "", "")}
+	runner := command.NewFakeRunner(
+		[]command.RunResult{{Args: []string{"mount", "-t", "cifs"}, ExitCode: 13}},
+		[]error{exitErr(t, 13)},
+	)
+	ret := driverMain(args, runner)
+	if ret.Status != retStatFailure || ret.Reason != "PermissionDenied" {
+		t.Fatalf("unexpected result: %+v", ret)
+	}
+}
+
+func TestDriverMainMountDeviceNotSupported(t *testing.T) {
+	ret := driverMain([]string{"driver", "mountdevice", "/mnt/foo", "/dev/sdz", `{}`}, command.NewFakeRunner(nil, nil))
+	if ret.Status != retStatNotSupported {
+		t.Fatalf("expected Not supported, got: %+v", ret)
+	}
+}
+
+func TestDetectMode(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"default flex", []string{"/usr/libexec/kubernetes/kubelet-plugins/volume/exec/hansemerkur~cifs/cifs"}, "flex"},
+		{"argv0 csi", []string{"/bin/cifs-csi-plugin"}, "csi"},
+		{"explicit flag", []string{"/bin/cifs", "--mode=csi"}, "csi"},
+		{"explicit flag after other flags", []string{"/bin/cifs", "--endpoint=unix:///tmp/csi.sock", "--mode=csi"}, "csi"},
+		{"explicit flag space form", []string{"/bin/cifs", "--mode", "csi"}, "csi"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := detectMode(tc.args); got != tc.want {
+				t.Fatalf("detectMode(%v) = %q, want %q", tc.args, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// metrics is the FlexVolume getmetrics response payload, matching the shape
+// kubelet expects from `kubectl get --raw .../metrics/resource`.
+type metrics struct {
+	Capacity  int64 `json:"capacity"`
+	Used      int64 `json:"used"`
+	Available int64 `json:"available"`
+}
+
+// getMetrics reports capacity/used/available bytes for the CIFS mount at
+// mountPath. f_blocks*f_bsize is exactly what the SMB server reports as the
+// share's size, so no per-file du-style scan is needed.
+func getMetrics(mountPath string) (metrics, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(mountPath, &stat); err != nil {
+		return metrics{}, errors.Wrapf(err, "Error statfs on mount path [path=%s]", mountPath)
+	}
+
+	blockSize := int64(stat.Bsize)
+	capacity := int64(stat.Blocks) * blockSize
+	available := int64(stat.Bavail) * blockSize
+	used := capacity - int64(stat.Bfree)*blockSize
+
+	return metrics{Capacity: capacity, Used: used, Available: available}, nil
+}
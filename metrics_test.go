@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestGetMetricsTmpfs(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := syscall.Mount("tmpfs", dir, "tmpfs", 0, "size=1m"); err != nil {
+		t.Skipf("skipping: could not mount tmpfs (need CAP_SYS_ADMIN): %s", err)
+	}
+	defer func() {
+		if err := syscall.Unmount(dir, 0); err != nil {
+			t.Logf("failed to unmount %s: %s", dir, err)
+		}
+	}()
+
+	const wantCapacity = 1024 * 1024
+
+	m, err := getMetrics(dir)
+	if err != nil {
+		t.Fatalf("getMetrics returned error: %s", err)
+	}
+	if m.Capacity != wantCapacity {
+		t.Fatalf("Capacity = %d, want %d", m.Capacity, wantCapacity)
+	}
+	if m.Available < 0 || m.Available > m.Capacity {
+		t.Fatalf("Available = %d out of range [0, %d]", m.Available, m.Capacity)
+	}
+	if m.Used < 0 || m.Used > m.Capacity {
+		t.Fatalf("Used = %d out of range [0, %d]", m.Used, m.Capacity)
+	}
+
+	f, err := os.Create(dir + "/data")
+	if err != nil {
+		t.Fatalf("failed to write test file: %s", err)
+	}
+	// Truncate only extends the apparent size of a sparse file; it must
+	// be written to for tmpfs to actually allocate pages for it, which is
+	// what statfs's block count (and therefore Used) reflects.
+	if _, err := f.Write(make([]byte, 512*1024)); err != nil {
+		t.Fatalf("failed to grow test file: %s", err)
+	}
+	f.Close()
+
+	after, err := getMetrics(dir)
+	if err != nil {
+		t.Fatalf("getMetrics returned error after write: %s", err)
+	}
+	if after.Used <= m.Used {
+		t.Fatalf("Used did not increase after writing a file: before=%d after=%d", m.Used, after.Used)
+	}
+}
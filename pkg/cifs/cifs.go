@@ -0,0 +1,201 @@
+// Package cifs builds the `mount -t cifs` invocation and classifies its
+// exit codes. It is shared by the FlexVolume entrypoint and the CSI node
+// server so both surfaces map the same mount.cifs failures to the same
+// causes.
+package cifs
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/HanseMerkur/kubernetes-cifs-volumedriver/pkg/command"
+)
+
+// ErrInvalidMounterArgs is returned by BuildCIFSMount when mArgs contains
+// neither a server/share pair nor a source.
+var ErrInvalidMounterArgs = errors.New("Invalid mounter arguments")
+
+// MounterArgs are the arguments needed to build a `mount -t cifs` command,
+// however they were sourced (FlexVolume JSON, CSI VolumeContext, ...).
+type MounterArgs struct {
+	FsGroup          string `json:"kubernetes.io/mounterArgs.FsGroup"`
+	FsGroupLegacy    string `json:"kubernetes.io/fsGroup"` // k8s prior to 1.15
+	FsType           string `json:"kubernetes.io/fsType"`
+	PodName          string `json:"kubernetes.io/pod.name"`
+	PodNamespace     string `json:"kubernetes.io/pod.namespace"`
+	PodUID           string `json:"kubernetes.io/pod.uid"`
+	PvName           string `json:"kubernetes.io/pvOrVolumeName"`
+	ReadWrite        string `json:"kubernetes.io/readwrite"`
+	ServiceAccount   string `json:"kubernetes.io/serviceAccount.name"`
+	MountOptions     string `json:"mountOptions"`
+	Opts             string `json:"opts"`
+	Server           string `json:"server"`
+	Share            string `json:"share"`
+	Source           string `json:"source"`
+	PasswdMethod     string `json:"passwdMethod"`
+	CredentialDomain string `json:"kubernetes.io/secret/domain"`
+	CredentialUser   string `json:"kubernetes.io/secret/username"`
+	CredentialPass   string `json:"kubernetes.io/secret/password"`
+	// CredentialKeytab and CredentialPrincipal are only used when
+	// PasswdMethod is "krb5"; see kerberos.go.
+	CredentialKeytab    string `json:"kubernetes.io/secret/keytab"`
+	CredentialPrincipal string `json:"kubernetes.io/secret/principal"`
+}
+
+// PasswdMethod values recognised by BuildCIFSMount, beyond the default of
+// plain domain/username/password mount options.
+const (
+	// PasswdMethodKrb5 authenticates via a keytab-backed Kerberos ticket
+	// instead of a username/password.
+	PasswdMethodKrb5 = "krb5"
+	// PasswdMethodMultiuser lets each pod on the share supply its own
+	// credentials via cifscreds, instead of one username/password for
+	// the whole mount.
+	PasswdMethodMultiuser = "multiuser"
+)
+
+func decodeBase64(field, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", errors.Wrapf(ErrBadCredentialEncoding, "%s: %s", field, err)
+	}
+	return string(decoded), nil
+}
+
+// DecodeCredentials base64-decodes the credential fields in place, the way
+// they arrive from Kubernetes secrets.
+func (ma *MounterArgs) DecodeCredentials() error {
+	var err error
+	if ma.CredentialDomain, err = decodeBase64("credential domain", ma.CredentialDomain); err != nil {
+		return err
+	}
+	if ma.CredentialUser, err = decodeBase64("credential user", ma.CredentialUser); err != nil {
+		return err
+	}
+	if ma.CredentialPass, err = decodeBase64("credential password", ma.CredentialPass); err != nil {
+		return err
+	}
+	if ma.CredentialKeytab, err = decodeBase64("credential keytab", ma.CredentialKeytab); err != nil {
+		return err
+	}
+	if ma.CredentialPrincipal, err = decodeBase64("credential principal", ma.CredentialPrincipal); err != nil {
+		return err
+	}
+
+	// If we got fsGroup from the legacy json field, assume k8s prior to 1.15
+	if ma.FsGroupLegacy != "" {
+		ma.FsGroup = ma.FsGroupLegacy
+	}
+	return nil
+}
+
+// BuildCIFSMount builds the `mount -t cifs` command for mArgs, mounting at
+// targetPath. It is shared by the FlexVolume `mount` verb and the CSI
+// NodePublishVolume RPC.
+func BuildCIFSMount(mArgs MounterArgs, targetPath string) (cmd *exec.Cmd, err error) {
+	var optsFinal []string
+	cmd = exec.Command("mount")
+	cmd.Args = append(cmd.Args, "-t")
+	cmd.Args = append(cmd.Args, "cifs")
+
+	if mArgs.FsGroup != "" {
+		optsFinal = append(optsFinal, fmt.Sprintf("uid=%s,gid=%s", mArgs.FsGroup, mArgs.FsGroup))
+	}
+	if mArgs.ReadWrite != "" {
+		optsFinal = append(optsFinal, mArgs.ReadWrite)
+	}
+	// multiuser mounts take no domain/username/password at all: each pod
+	// on the share authenticates separately via cifscreds.
+	if mArgs.PasswdMethod != PasswdMethodMultiuser {
+		if mArgs.CredentialDomain != "" {
+			optsFinal = append(optsFinal, fmt.Sprintf("domain=%s", strings.Trim(mArgs.CredentialDomain, "\n\r")))
+		}
+		if mArgs.CredentialUser != "" {
+			optsFinal = append(optsFinal, fmt.Sprintf("username=%s", strings.Trim(mArgs.CredentialUser, "\n\r")))
+		}
+		if mArgs.CredentialPass != "" {
+			cmd.Env = append(os.Environ(), fmt.Sprintf("PASSWD=%s", strings.Trim(mArgs.CredentialPass, "\n\r")))
+		}
+	}
+	switch mArgs.PasswdMethod {
+	case PasswdMethodKrb5:
+		optsFinal = append(optsFinal, fmt.Sprintf("sec=krb5,cruid=%s", krb5Cruid(mArgs)))
+		cmd.Env = append(os.Environ(), fmt.Sprintf("KRB5CCNAME=%s", krb5CachePath(mArgs)))
+	case PasswdMethodMultiuser:
+		optsFinal = append(optsFinal, "sec=ntlmssp,multiuser")
+	}
+	if mArgs.Opts != "" {
+		optsFinal = append(optsFinal, strings.Split(mArgs.Opts, ",")...)
+	} else if mArgs.MountOptions != "" {
+		optsFinal = append(optsFinal, strings.Split(mArgs.MountOptions, ",")...)
+	}
+	if len(optsFinal) > 0 {
+		cmd.Args = append(cmd.Args, "-o", strings.Join(optsFinal, ","))
+	}
+
+	if mArgs.Server != "" && mArgs.Share != "" {
+		cmd.Args = append(cmd.Args, fmt.Sprintf("//%s%s", mArgs.Server, mArgs.Share))
+	} else if mArgs.Source != "" {
+		cmd.Args = append(cmd.Args, mArgs.Source)
+	} else {
+		return nil, ErrInvalidMounterArgs
+	}
+
+	cmd.Args = append(cmd.Args, targetPath)
+
+	return cmd, nil
+}
+
+func argsContain(args []string, item string) bool {
+	for _, arg := range args {
+		if arg == item {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassifyMountError maps a failed mount.cifs/umount.cifs invocation to the
+// cause it corresponds to, so every entrypoint (FlexVolume, CSI, and the
+// containerized mounter's pod-exec runner) reports the same causes for the
+// same exit codes. Classification is driven by rr.ExitCode (plus rr.Args
+// for the nodfs case) so it works regardless of which command.Runner
+// produced err.
+func ClassifyMountError(rr command.RunResult, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	mountErr := &ErrMountFailed{Cmd: rr.Command(), ExitCode: rr.ExitCode, Stderr: rr.Stderr.String(), cause: err}
+
+	switch {
+	case rr.ExitCode == 13:
+		// Failed to authenticate against CIFS Server
+		mountErr.Reason = ReasonPermissionDenied
+	case rr.ExitCode == 5 && argsContain(rr.Args, "nodfs"):
+		// Input/Output Error with Code 5 plus a nodfs option is almost certainly a DFS-Share failure
+		mountErr.Reason = ReasonDFSFailure
+	case rr.ExitCode == 32:
+		mountErr.Reason = ReasonInvalidParameters
+	case rr.ExitCode != 0:
+		// The program has exited with an exit code != 0
+		mountErr.Reason = ReasonMountFailed
+	default:
+		return err
+	}
+	return mountErr
+}
+
+// RunMount runs cmd via runner and classifies the result.
+func RunMount(runner command.Runner, cmd *exec.Cmd) error {
+	rr, err := runner.RunCmd(cmd)
+	return ClassifyMountError(rr, err)
+}
@@ -0,0 +1,86 @@
+package cifs
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/HanseMerkur/kubernetes-cifs-volumedriver/pkg/command"
+)
+
+// exitErr runs a shell that exits with code, returning the resulting
+// *exec.ExitError so tests can script realistic command.Runner failures.
+func exitErr(t *testing.T, code int) error {
+	t.Helper()
+	err := exec.Command("sh", "-c", "exit "+strconv.Itoa(code)).Run()
+	if err == nil {
+		t.Fatalf("expected exit %d to produce an error", code)
+	}
+	return err
+}
+
+func TestBuildCIFSMountMultiuserOmitsCredentials(t *testing.T) {
+	mArgs := MounterArgs{
+		PasswdMethod:     PasswdMethodMultiuser,
+		Server:           "myserver",
+		Share:            "/myshare",
+		CredentialDomain: "EXAMPLE",
+		CredentialUser:   "alice",
+		CredentialPass:   "hunter2",
+	}
+	cmd, err := BuildCIFSMount(mArgs, "/mnt/foo")
+	if err != nil {
+		t.Fatalf("BuildCIFSMount returned error: %s", err)
+	}
+	argv := strings.Join(cmd.Args, " ")
+	if strings.Contains(argv, "domain=") || strings.Contains(argv, "username=") {
+		t.Fatalf("expected multiuser mount to omit domain/username, got argv: %v", cmd.Args)
+	}
+	if !strings.Contains(argv, "sec=ntlmssp,multiuser") {
+		t.Fatalf("expected sec=ntlmssp,multiuser option in argv: %v", cmd.Args)
+	}
+	for _, e := range cmd.Env {
+		if strings.HasPrefix(e, "PASSWD=") {
+			t.Fatalf("expected multiuser mount to omit PASSWD env, got: %v", cmd.Env)
+		}
+	}
+}
+
+func TestClassifyMountError(t *testing.T) {
+	cases := []struct {
+		name       string
+		exitCode   int
+		args       []string
+		wantSub    string
+		wantReason Reason
+	}{
+		{"permission denied", 13, []string{"mount", "-t", "cifs"}, "Permission denied", ReasonPermissionDenied},
+		{"dfs failure", 5, []string{"mount", "-t", "cifs", "-o", "nodfs"}, "DFS-Share", ReasonDFSFailure},
+		{"bad parameters", 32, []string{"mount", "-t", "cifs"}, "Check parameters", ReasonInvalidParameters},
+		{"generic failure", 1, []string{"mount", "-t", "cifs"}, "Error running cmd", ReasonMountFailed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			runner := command.NewFakeRunner(
+				[]command.RunResult{{Args: tc.args, ExitCode: tc.exitCode}},
+				[]error{exitErr(t, tc.exitCode)},
+			)
+			cmd := exec.Command(tc.args[0], tc.args[1:]...)
+			err := RunMount(runner, cmd)
+			if err == nil || !strings.Contains(err.Error(), tc.wantSub) {
+				t.Fatalf("expected error containing %q, got %v", tc.wantSub, err)
+			}
+			var mountErr *ErrMountFailed
+			if !errors.As(err, &mountErr) {
+				t.Fatalf("expected *ErrMountFailed, got %T", err)
+			}
+			if mountErr.Reason != tc.wantReason {
+				t.Fatalf("expected reason %q, got %q", tc.wantReason, mountErr.Reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package cifs
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Reason is a machine-readable classification of a mount/credential
+// failure, stable across releases so callers (FlexVolume's ret.Reason,
+// CSI status details) can filter on a cause instead of regexing the
+// human-readable message.
+type Reason string
+
+const (
+	ReasonNone                  Reason = ""
+	ReasonInvalidMounterArgs    Reason = "InvalidMounterArgs"
+	ReasonBadCredentialEncoding Reason = "BadCredentialEncoding"
+	ReasonPermissionDenied      Reason = "PermissionDenied"
+	ReasonDFSFailure            Reason = "DFSFailure"
+	ReasonInvalidParameters     Reason = "InvalidParameters"
+	ReasonMountFailed           Reason = "MountFailed"
+)
+
+// ErrBadCredentialEncoding is the cause wrapped by decodeBase64 when a
+// credential field isn't valid base64, letting callers detect it via
+// errors.Is without matching on message text.
+var ErrBadCredentialEncoding = errors.New("Error decoding credential")
+
+// ErrMountFailed is returned by ClassifyMountError when mount.cifs/umount
+// exits with a non-zero status. ExitCode and Stderr are exposed so callers
+// can act on the same causes ClassifyMountError already recognizes (see
+// Reason) without parsing Error().
+type ErrMountFailed struct {
+	Cmd      string
+	ExitCode int
+	Stderr   string
+	Reason   Reason
+	cause    error
+}
+
+func (e *ErrMountFailed) Error() string {
+	switch e.Reason {
+	case ReasonPermissionDenied:
+		return fmt.Sprintf("Permission denied for cmd [cmd=%s] [response=%s]: %s", e.Cmd, e.Stderr, e.cause)
+	case ReasonDFSFailure:
+		return fmt.Sprintf("Cannot mount a DFS-Share with option nodfs [cmd=%s] [response=%s]: %s", e.Cmd, e.Stderr, e.cause)
+	case ReasonInvalidParameters:
+		return fmt.Sprintf("Could not mount volume. Check parameters [cmd=%s] [response=%s]: %s", e.Cmd, e.Stderr, e.cause)
+	default:
+		return fmt.Sprintf("Error running cmd [cmd=%s] [response=%s]: %s", e.Cmd, e.Stderr, e.cause)
+	}
+}
+
+func (e *ErrMountFailed) Unwrap() error { return e.cause }
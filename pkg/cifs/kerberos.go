@@ -0,0 +1,79 @@
+package cifs
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+
+	"github.com/HanseMerkur/kubernetes-cifs-volumedriver/pkg/command"
+)
+
+// ErrMissingKerberosCredentials is returned by PrepareKerberosAuth when
+// PasswdMethod is krb5 but no keytab/principal was supplied.
+var ErrMissingKerberosCredentials = errors.New("krb5 auth requires both kubernetes.io/secret/keytab and kubernetes.io/secret/principal")
+
+// krb5Cruid returns the uid that sec=krb5's cruid mount option should name:
+// the pod's fsGroup, since the credential cache kinit populates belongs to
+// the pod that will use the mount, not to the root driver process running
+// kinit. Falls back to the driver's own uid when fsGroup wasn't supplied.
+//
+// Note cruid wants a uid and FsGroup is a gid; BuildCIFSMount already makes
+// the same assumption for its own uid=/gid= option, so this follows that
+// existing convention rather than introducing a new one, but it means a
+// pod whose runAsUser differs from its fsGroup still gets the wrong cruid.
+func krb5Cruid(mArgs MounterArgs) string {
+	if mArgs.FsGroup != "" {
+		return mArgs.FsGroup
+	}
+	return fmt.Sprintf("%d", os.Getuid())
+}
+
+// krb5CachePath returns the credential cache path for mArgs, keyed by the
+// same cruid the `sec=krb5,cruid=...` mount option carries. cifs.upcall
+// resolves a cruid to its cache via krb5.conf's default_ccache_name (which
+// defaults to /tmp/krb5cc_<uid>, same as kinit's own default), not by
+// reading KRB5CCNAME out of the mount process's environment -- so the path
+// kinit is told to write to here must be the one that default resolves to
+// for this cruid, or the kernel upcall won't find it.
+func krb5CachePath(mArgs MounterArgs) string {
+	return "/tmp/krb5cc_" + krb5Cruid(mArgs)
+}
+
+// PrepareKerberosAuth runs `kinit -kt <keytab> <principal>` for mArgs when
+// PasswdMethod is krb5, populating the credential cache that the
+// `sec=krb5,cruid=...` mount option set by BuildCIFSMount reads from. It is
+// a no-op for any other PasswdMethod.
+func PrepareKerberosAuth(runner command.Runner, mArgs MounterArgs) error {
+	if mArgs.PasswdMethod != PasswdMethodKrb5 {
+		return nil
+	}
+	if mArgs.CredentialKeytab == "" || mArgs.CredentialPrincipal == "" {
+		return ErrMissingKerberosCredentials
+	}
+
+	keytabPath, err := writeKeytabFile(mArgs.CredentialKeytab)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(keytabPath)
+
+	cmd := exec.Command("kinit", "-kt", keytabPath, mArgs.CredentialPrincipal)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("KRB5CCNAME=%s", krb5CachePath(mArgs)))
+
+	return RunMount(runner, cmd)
+}
+
+func writeKeytabFile(keytab string) (string, error) {
+	f, err := os.CreateTemp("", "cifs-keytab-*")
+	if err != nil {
+		return "", errors.Wrap(err, "Error creating keytab file")
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(keytab); err != nil {
+		return "", errors.Wrap(err, "Error writing keytab file")
+	}
+	return f.Name(), nil
+}
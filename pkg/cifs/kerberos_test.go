@@ -0,0 +1,96 @@
+package cifs
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/HanseMerkur/kubernetes-cifs-volumedriver/pkg/command"
+)
+
+func TestPrepareKerberosAuthNoop(t *testing.T) {
+	runner := command.NewFakeRunner(nil, nil)
+	mArgs := MounterArgs{PasswdMethod: "", Server: "myserver", Share: "/myshare"}
+	if err := PrepareKerberosAuth(runner, mArgs); err != nil {
+		t.Fatalf("expected no-op for non-krb5 PasswdMethod, got: %s", err)
+	}
+	if len(runner.Cmds) != 0 {
+		t.Fatalf("expected no commands to run, got %v", runner.Cmds)
+	}
+}
+
+func TestPrepareKerberosAuthMissingCredentials(t *testing.T) {
+	runner := command.NewFakeRunner(nil, nil)
+	mArgs := MounterArgs{PasswdMethod: PasswdMethodKrb5, Server: "myserver", Share: "/myshare"}
+	if err := PrepareKerberosAuth(runner, mArgs); err != ErrMissingKerberosCredentials {
+		t.Fatalf("expected ErrMissingKerberosCredentials, got: %v", err)
+	}
+}
+
+func TestPrepareKerberosAuthRunsKinit(t *testing.T) {
+	runner := command.NewFakeRunner([]command.RunResult{{}}, []error{nil})
+	mArgs := MounterArgs{
+		PasswdMethod:        PasswdMethodKrb5,
+		PvName:              "my-pv",
+		CredentialKeytab:    base64.StdEncoding.EncodeToString([]byte("fake-keytab-bytes")),
+		CredentialPrincipal: base64.StdEncoding.EncodeToString([]byte("cifs/my-pv@EXAMPLE.COM")),
+	}
+	if err := mArgs.DecodeCredentials(); err != nil {
+		t.Fatalf("DecodeCredentials returned error: %s", err)
+	}
+
+	if err := PrepareKerberosAuth(runner, mArgs); err != nil {
+		t.Fatalf("PrepareKerberosAuth returned error: %s", err)
+	}
+
+	if len(runner.Cmds) != 1 {
+		t.Fatalf("expected exactly one command to run, got %d", len(runner.Cmds))
+	}
+	cmd := runner.Cmds[0]
+	if cmd.Args[0] != "kinit" || cmd.Args[len(cmd.Args)-1] != "cifs/my-pv@EXAMPLE.COM" {
+		t.Fatalf("unexpected kinit argv: %v", cmd.Args)
+	}
+
+	var gotCCName string
+	for _, e := range cmd.Env {
+		if strings.HasPrefix(e, "KRB5CCNAME=") {
+			gotCCName = e
+		}
+	}
+	if gotCCName != "KRB5CCNAME="+krb5CachePath(mArgs) {
+		t.Fatalf("unexpected KRB5CCNAME env entry: %q", gotCCName)
+	}
+}
+
+func TestBuildCIFSMountKrb5Options(t *testing.T) {
+	mArgs := MounterArgs{PasswdMethod: PasswdMethodKrb5, Server: "myserver", Share: "/myshare", PvName: "my-pv"}
+	cmd, err := BuildCIFSMount(mArgs, "/mnt/foo")
+	if err != nil {
+		t.Fatalf("BuildCIFSMount returned error: %s", err)
+	}
+	if !strings.Contains(strings.Join(cmd.Args, " "), "sec=krb5,cruid=") {
+		t.Fatalf("expected sec=krb5,cruid= option in argv: %v", cmd.Args)
+	}
+}
+
+func TestBuildCIFSMountKrb5CruidFromFsGroup(t *testing.T) {
+	mArgs := MounterArgs{PasswdMethod: PasswdMethodKrb5, Server: "myserver", Share: "/myshare", FsGroup: "1234"}
+	cmd, err := BuildCIFSMount(mArgs, "/mnt/foo")
+	if err != nil {
+		t.Fatalf("BuildCIFSMount returned error: %s", err)
+	}
+	if !strings.Contains(strings.Join(cmd.Args, " "), "cruid=1234") {
+		t.Fatalf("expected cruid to come from FsGroup, got argv: %v", cmd.Args)
+	}
+}
+
+// TestKrb5CachePathMatchesCruid guards the invariant PrepareKerberosAuth and
+// BuildCIFSMount both depend on: kinit must write the ccache to the same
+// path cifs.upcall resolves for the cruid the mount option carries, or the
+// kernel will never find the ticket.
+func TestKrb5CachePathMatchesCruid(t *testing.T) {
+	mArgs := MounterArgs{PasswdMethod: PasswdMethodKrb5, Server: "myserver", Share: "/myshare", FsGroup: "1234"}
+	if want, got := "/tmp/krb5cc_"+krb5Cruid(mArgs), krb5CachePath(mArgs); got != want {
+		t.Fatalf("krb5CachePath() = %q, want %q (cruid=%s)", got, want, krb5Cruid(mArgs))
+	}
+}
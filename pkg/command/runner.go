@@ -0,0 +1,91 @@
+// Package command abstracts running external commands so that callers such
+// as the FlexVolume and CSI mount paths can be exercised in tests without
+// invoking mount/umount for real.
+package command
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// RunResult captures the outcome of a single Runner invocation, so
+// callers can inspect stdout/stderr/exit code without re-running the command.
+type RunResult struct {
+	Args     []string
+	Stdout   bytes.Buffer
+	Stderr   bytes.Buffer
+	ExitCode int
+}
+
+// Command returns a human-readable form of the executed command, suitable
+// for log lines and error messages.
+func (rr RunResult) Command() string {
+	return strings.Join(rr.Args, " ")
+}
+
+// Runner abstracts running an *exec.Cmd so that driver entrypoints can be
+// exercised in tests without invoking mount/umount for real.
+type Runner interface {
+	RunCmd(cmd *exec.Cmd) (RunResult, error)
+}
+
+// ExecRunner is a Runner that shells out via os/exec, same as the driver
+// has always done.
+type ExecRunner struct{}
+
+// RunCmd implements Runner by actually starting and waiting for cmd.
+func (ExecRunner) RunCmd(cmd *exec.Cmd) (RunResult, error) {
+	rr := RunResult{Args: cmd.Args}
+	cmd.Stdout = &rr.Stdout
+	cmd.Stderr = &rr.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return rr, errors.Wrapf(err, "Error start cmd [cmd=%s]", rr.Command())
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
+				rr.ExitCode = status.ExitStatus()
+			}
+			return rr, err
+		}
+		return rr, errors.Wrapf(err, "Error waiting for cmd to finish [cmd=%s]", rr.Command())
+	}
+	return rr, nil
+}
+
+// FakeRunner is a Runner that records the *exec.Cmd it was asked to run and
+// returns scripted results, for use in tests.
+type FakeRunner struct {
+	Cmds    []*exec.Cmd
+	Results []RunResult
+	Errs    []error
+}
+
+// NewFakeRunner builds a FakeRunner that returns results[i]/errs[i] on its
+// i-th call to RunCmd, and a zero RunResult with a nil error once the
+// scripted results are exhausted.
+func NewFakeRunner(results []RunResult, errs []error) *FakeRunner {
+	return &FakeRunner{Results: results, Errs: errs}
+}
+
+// RunCmd implements Runner without touching the host.
+func (f *FakeRunner) RunCmd(cmd *exec.Cmd) (RunResult, error) {
+	f.Cmds = append(f.Cmds, cmd)
+	i := len(f.Cmds) - 1
+	if i < len(f.Results) {
+		rr := f.Results[i]
+		rr.Args = cmd.Args
+		var err error
+		if i < len(f.Errs) {
+			err = f.Errs[i]
+		}
+		return rr, err
+	}
+	return RunResult{Args: cmd.Args}, nil
+}
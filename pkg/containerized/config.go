@@ -0,0 +1,59 @@
+// Package containerized runs mount.cifs/umount.cifs inside a privileged
+// sidecar pod instead of requiring cifs-utils on the host, for nodes with
+// minimal OS images (CoreOS, Talos, Bottlerocket, ...).
+package containerized
+
+const (
+	// containerName is the name of the cifs-utils container inside the
+	// mount pod that mount/umount are exec'd into.
+	containerName = "cifs-utils"
+
+	// labelManagedBy marks pods created by this mounter, so they can be
+	// told apart from unrelated pods on the node.
+	labelManagedBy = "cifs.hansemerkur.de/managed-by"
+	// managedByValue is the labelManagedBy value this mounter sets.
+	managedByValue = "containerized-mounter"
+	// labelPVName records which PersistentVolume a mount pod serves, so
+	// ReconcileMountPods can garbage collect it once the PV is no longer
+	// published. Reuse for a later mount/unmount goes by the pod's name
+	// (see mountPodName), not by this label.
+	labelPVName = "cifs.hansemerkur.de/pv-name"
+	// labelNodeName records which node a mount pod was scheduled to,
+	// since ReconcileMountPods only ever looks at pods on its own node.
+	labelNodeName = "cifs.hansemerkur.de/node-name"
+)
+
+// FuseContainerConfig configures the sidecar pod that cifs-utils runs in,
+// mirroring the containerized-fuse-mounter pattern used by other CSI
+// drivers.
+type FuseContainerConfig struct {
+	// Image is the cifs-utils image to run, e.g. "hansemerkur/cifs-utils:latest".
+	Image string
+	// Namespace the mount pods are created in.
+	Namespace string
+	// CPURequest/CPULimit/MemoryRequest/MemoryLimit are passed straight
+	// through to the pod's resource requirements (e.g. "50m", "64Mi").
+	// Any left empty is omitted from the pod spec.
+	CPURequest    string
+	CPULimit      string
+	MemoryRequest string
+	MemoryLimit   string
+	// HostPodsDir is the host path containing kubelet's per-pod volume
+	// directories, bind-mounted Bidirectional into the mount pod so
+	// mounts made inside it are visible to the host (and to kubelet).
+	HostPodsDir string
+}
+
+func (c FuseContainerConfig) namespace() string {
+	if c.Namespace != "" {
+		return c.Namespace
+	}
+	return "kube-system"
+}
+
+func (c FuseContainerConfig) hostPodsDir() string {
+	if c.HostPodsDir != "" {
+		return c.HostPodsDir
+	}
+	return "/var/lib/kubelet/pods"
+}
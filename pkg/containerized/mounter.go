@@ -0,0 +1,178 @@
+package containerized
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/HanseMerkur/kubernetes-cifs-volumedriver/pkg/command"
+)
+
+// Mounter runs mount.cifs/umount.cifs inside a per-PV sidecar pod on the
+// same node, instead of requiring cifs-utils to be installed on the host.
+type Mounter struct {
+	clientset  kubernetes.Interface
+	restConfig *rest.Config
+	config     FuseContainerConfig
+}
+
+// NewMounter builds a Mounter that creates pods via clientset, described by
+// config.
+func NewMounter(clientset kubernetes.Interface, restConfig *rest.Config, config FuseContainerConfig) *Mounter {
+	return &Mounter{clientset: clientset, restConfig: restConfig, config: config}
+}
+
+// Runner returns a command.Runner that execs mount/umount inside the mount
+// pod for pvName on nodeName, creating that pod if it doesn't already
+// exist. The returned pod's exit codes flow back through the exec stream,
+// so cifs.ClassifyMountError still applies to its results.
+func (m *Mounter) Runner(ctx context.Context, nodeName, pvName string) (command.Runner, error) {
+	pod, err := m.ensureMountPod(ctx, nodeName, pvName)
+	if err != nil {
+		return nil, err
+	}
+	return &PodRunner{
+		clientset:  m.clientset,
+		restConfig: m.restConfig,
+		namespace:  pod.Namespace,
+		podName:    pod.Name,
+		container:  containerName,
+	}, nil
+}
+
+func mountPodName(pvName string) string {
+	return "cifs-mount-" + pvName
+}
+
+func (m *Mounter) ensureMountPod(ctx context.Context, nodeName, pvName string) (*corev1.Pod, error) {
+	namespace := m.config.namespace()
+	name := mountPodName(pvName)
+
+	pod, err := m.clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return waitForRunning(ctx, m.clientset, pod)
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to look up mount pod %s/%s: %w", namespace, name, err)
+	}
+
+	pod = m.podSpec(nodeName, pvName)
+	pod, err = m.clientset.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mount pod %s/%s: %w", namespace, name, err)
+	}
+	return waitForRunning(ctx, m.clientset, pod)
+}
+
+func waitForRunning(ctx context.Context, clientset kubernetes.Interface, pod *corev1.Pod) (*corev1.Pod, error) {
+	for {
+		if pod.Status.Phase == corev1.PodRunning {
+			return pod, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for mount pod %s/%s to become Running: %w", pod.Namespace, pod.Name, ctx.Err())
+		case <-time.After(500 * time.Millisecond):
+		}
+		var err error
+		pod, err = clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to poll mount pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+	}
+}
+
+func (m *Mounter) podSpec(nodeName, pvName string) *corev1.Pod {
+	privileged := true
+	bidirectional := corev1.MountPropagationBidirectional
+
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{},
+		Limits:   corev1.ResourceList{},
+	}
+	setQuantity(resources.Requests, corev1.ResourceCPU, m.config.CPURequest)
+	setQuantity(resources.Requests, corev1.ResourceMemory, m.config.MemoryRequest)
+	setQuantity(resources.Limits, corev1.ResourceCPU, m.config.CPULimit)
+	setQuantity(resources.Limits, corev1.ResourceMemory, m.config.MemoryLimit)
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mountPodName(pvName),
+			Namespace: m.config.namespace(),
+			Labels: map[string]string{
+				labelManagedBy: managedByValue,
+				labelPVName:    pvName,
+				labelNodeName:  nodeName,
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:      nodeName,
+			RestartPolicy: corev1.RestartPolicyAlways,
+			Containers: []corev1.Container{
+				{
+					Name:      containerName,
+					Image:     m.config.Image,
+					Command:   []string{"sleep", "infinity"},
+					Resources: resources,
+					SecurityContext: &corev1.SecurityContext{
+						Privileged: &privileged,
+					},
+					VolumeMounts: []corev1.VolumeMount{
+						{
+							Name:             "kubelet-pods",
+							MountPath:        m.config.hostPodsDir(),
+							MountPropagation: &bidirectional,
+						},
+					},
+				},
+			},
+			Volumes: []corev1.Volume{
+				{
+					Name: "kubelet-pods",
+					VolumeSource: corev1.VolumeSource{
+						HostPath: &corev1.HostPathVolumeSource{
+							Path: m.config.hostPodsDir(),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func setQuantity(list corev1.ResourceList, name corev1.ResourceName, value string) {
+	if value == "" {
+		return
+	}
+	list[name] = resource.MustParse(value)
+}
+
+// ReconcileMountPods deletes mount pods on nodeName whose PV is no longer
+// active, so pods left behind by a node restart or a missed unmount don't
+// accumulate.
+func (m *Mounter) ReconcileMountPods(ctx context.Context, nodeName string, activePVNames map[string]bool) error {
+	namespace := m.config.namespace()
+	pods, err := m.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s,%s=%s", labelManagedBy, managedByValue, labelNodeName, nodeName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list mount pods on node %s: %w", nodeName, err)
+	}
+
+	for _, pod := range pods.Items {
+		if activePVNames[pod.Labels[labelPVName]] {
+			continue
+		}
+		if err := m.clientset.CoreV1().Pods(namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to garbage collect mount pod %s/%s: %w", namespace, pod.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package containerized
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"github.com/HanseMerkur/kubernetes-cifs-volumedriver/pkg/command"
+)
+
+// PodRunner is a command.Runner that execs the given *exec.Cmd inside an
+// already-running container via the Kubernetes exec subresource, rather
+// than running it on the host. It only looks at cmd.Args/cmd.Env; cmd is
+// never actually started locally.
+type PodRunner struct {
+	clientset  kubernetes.Interface
+	restConfig *rest.Config
+	namespace  string
+	podName    string
+	container  string
+}
+
+// RunCmd streams cmd.Args to the mount pod's container and captures its
+// stdout/stderr/exit code, so cifs.ClassifyMountError can be applied to the
+// result exactly as it is for a local run.
+func (r *PodRunner) RunCmd(cmd *exec.Cmd) (command.RunResult, error) {
+	rr := command.RunResult{Args: cmd.Args}
+
+	req := r.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(r.namespace).
+		Name(r.podName).
+		SubResource("exec")
+	req.VersionedParams(&corev1.PodExecOptions{
+		Container: r.container,
+		Command:   execCommand(cmd),
+		Stdout:    true,
+		Stderr:    true,
+	}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(r.restConfig, "POST", req.URL())
+	if err != nil {
+		return rr, err
+	}
+
+	err = executor.Stream(remotecommand.StreamOptions{
+		Stdout: &rr.Stdout,
+		Stderr: &rr.Stderr,
+	})
+	if err != nil {
+		if codeErr, ok := err.(utilexec.CodeExitError); ok {
+			rr.ExitCode = codeErr.Code
+		}
+		return rr, err
+	}
+	return rr, nil
+}
+
+// execCommand returns the argv to send over the exec subresource. The exec
+// subresource has no notion of a per-invocation environment, so a PASSWD=
+// entry on cmd.Env is instead inlined into a "sh -c" wrapper. Any other
+// cmd.Env entry (such as the KRB5CCNAME the cifs mount builder sets for
+// krb5) is not passed on.
+func execCommand(cmd *exec.Cmd) []string {
+	passwd, ok := findEnv(cmd.Env, "PASSWD")
+	if !ok {
+		return cmd.Args
+	}
+	return []string{"sh", "-c", fmt.Sprintf("PASSWD=%s exec %s", shellQuote(passwd), shellQuoteArgs(cmd.Args))}
+}
+
+func findEnv(env []string, key string) (string, bool) {
+	prefix := key + "="
+	for _, kv := range env {
+		if strings.HasPrefix(kv, prefix) {
+			return strings.TrimPrefix(kv, prefix), true
+		}
+	}
+	return "", false
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func shellQuoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,37 @@
+package containerized
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestExecCommandNoEnv(t *testing.T) {
+	cmd := exec.Command("mount", "-t", "cifs", "//server/share", "/mnt/foo")
+	got := execCommand(cmd)
+	if !reflect.DeepEqual(got, cmd.Args) {
+		t.Fatalf("execCommand() = %v, want cmd.Args unchanged: %v", got, cmd.Args)
+	}
+}
+
+func TestExecCommandInlinesPasswd(t *testing.T) {
+	cmd := exec.Command("mount", "-t", "cifs", "//server/share", "/mnt/foo")
+	cmd.Env = []string{"PASSWD=hunter2"}
+
+	got := execCommand(cmd)
+	want := []string{"sh", "-c", "PASSWD='hunter2' exec 'mount' '-t' 'cifs' '//server/share' '/mnt/foo'"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("execCommand() = %v, want %v", got, want)
+	}
+}
+
+func TestExecCommandQuotesSingleQuotesInPasswd(t *testing.T) {
+	cmd := exec.Command("mount")
+	cmd.Env = []string{"PASSWD=o'brien"}
+
+	got := execCommand(cmd)
+	want := []string{"sh", "-c", `PASSWD='o'\''brien' exec 'mount'`}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("execCommand() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,147 @@
+// Package csi implements a CSI plugin for CIFS/SMB shares, as an
+// alternative to the FlexVolume entrypoint in package main. It reuses the
+// mount-option construction and exit-code classification from pkg/cifs so
+// both entrypoints behave identically on the wire.
+package csi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+
+	"github.com/HanseMerkur/kubernetes-cifs-volumedriver/pkg/command"
+	"github.com/HanseMerkur/kubernetes-cifs-volumedriver/pkg/containerized"
+)
+
+const (
+	// DriverName is reported to Kubernetes via GetPluginInfo.
+	DriverName = "cifs.csi.hansemerkur.de"
+	// DriverVersion is reported to Kubernetes via GetPluginInfo.
+	DriverVersion = "0.1.0"
+)
+
+// Driver implements the CSI Identity, Node and Controller services on top
+// of mount.cifs.
+type Driver struct {
+	nodeID string
+	runner command.Runner
+
+	// containerizedMounter, when set, runs mount/umount inside a
+	// per-volume sidecar pod instead of via runner, for nodes without
+	// cifs-utils installed on the host.
+	containerizedMounter *containerized.Mounter
+
+	// activeVolumes tracks the volume IDs currently published on this
+	// node (successful NodePublishVolume, not yet NodeUnpublishVolume'd),
+	// so ReconcileMountPods knows which mount pods are still needed.
+	activeVolumesMu sync.Mutex
+	activeVolumes   map[string]bool
+
+	csi.UnimplementedControllerServer
+	csi.UnimplementedNodeServer
+}
+
+// NewDriver builds a Driver for nodeID, running mount/umount via runner.
+func NewDriver(nodeID string, runner command.Runner) *Driver {
+	return &Driver{nodeID: nodeID, runner: runner, activeVolumes: map[string]bool{}}
+}
+
+// WithContainerizedMounter switches the driver to run mount/umount inside a
+// sidecar pod managed by m, rather than via the runner passed to NewDriver.
+func (d *Driver) WithContainerizedMounter(m *containerized.Mounter) *Driver {
+	d.containerizedMounter = m
+	return d
+}
+
+// Run starts serving the CSI gRPC endpoints on endpoint (a unix:// or tcp://
+// address) until the process is terminated.
+func (d *Driver) Run(endpoint string) error {
+	proto, addr, err := parseEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	if proto == "unix" {
+		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale socket %s: %w", addr, err)
+		}
+	}
+
+	listener, err := net.Listen(proto, addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", endpoint, err)
+	}
+
+	server := grpc.NewServer()
+	csi.RegisterIdentityServer(server, d)
+	csi.RegisterNodeServer(server, d)
+	csi.RegisterControllerServer(server, d)
+
+	return server.Serve(listener)
+}
+
+// trackVolumeActive records volumeID as published on this node.
+func (d *Driver) trackVolumeActive(volumeID string) {
+	d.activeVolumesMu.Lock()
+	defer d.activeVolumesMu.Unlock()
+	d.activeVolumes[volumeID] = true
+}
+
+// trackVolumeInactive forgets volumeID after it has been unpublished.
+func (d *Driver) trackVolumeInactive(volumeID string) {
+	d.activeVolumesMu.Lock()
+	defer d.activeVolumesMu.Unlock()
+	delete(d.activeVolumes, volumeID)
+}
+
+// activeVolumeSnapshot returns a copy of the currently tracked volume IDs,
+// safe to hand to containerized.Mounter.ReconcileMountPods without holding
+// activeVolumesMu for the duration of the API calls it makes.
+func (d *Driver) activeVolumeSnapshot() map[string]bool {
+	d.activeVolumesMu.Lock()
+	defer d.activeVolumesMu.Unlock()
+	snapshot := make(map[string]bool, len(d.activeVolumes))
+	for id := range d.activeVolumes {
+		snapshot[id] = true
+	}
+	return snapshot
+}
+
+// RunMountPodReconciler periodically garbage-collects this node's mount
+// pods for volumes that are no longer published, so pods left behind by a
+// node restart or a missed NodeUnpublishVolume don't accumulate. It blocks
+// until ctx is done and is a no-op if the driver has no containerized
+// mounter configured.
+func (d *Driver) RunMountPodReconciler(ctx context.Context, interval time.Duration) {
+	if d.containerizedMounter == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := d.containerizedMounter.ReconcileMountPods(ctx, d.nodeID, d.activeVolumeSnapshot()); err != nil {
+			log.Printf("WARNING: mount pod reconciliation failed: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func parseEndpoint(endpoint string) (proto, addr string, err error) {
+	for _, p := range []string{"unix", "tcp"} {
+		prefix := p + "://"
+		if len(endpoint) > len(prefix) && endpoint[:len(prefix)] == prefix {
+			return p, endpoint[len(prefix):], nil
+		}
+	}
+	return "", "", fmt.Errorf("invalid CSI endpoint %q, expected unix:// or tcp:// prefix", endpoint)
+}
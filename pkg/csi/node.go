@@ -0,0 +1,218 @@
+package csi
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/HanseMerkur/kubernetes-cifs-volumedriver/pkg/cifs"
+	"github.com/HanseMerkur/kubernetes-cifs-volumedriver/pkg/command"
+)
+
+// NodePublishVolume mounts the CIFS share described by req at req.TargetPath
+// via mount.cifs, reusing the same option-building and exit-code
+// classification as the FlexVolume `mount` verb.
+func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	if req.GetTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "target path missing in request")
+	}
+
+	mArgs, err := mounterArgsFromRequest(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if d.containerizedMounter != nil && mArgs.PasswdMethod == cifs.PasswdMethodKrb5 {
+		// PrepareKerberosAuth's kinit and the mount's KRB5CCNAME both
+		// need a path the sidecar pod's filesystem actually has; the pod
+		// exec runner doesn't stage the keytab or carry KRB5CCNAME into the
+		// pod (it only inlines PASSWD), so treat the combination as unsupported rather than fail
+		// confusingly at mount time.
+		return nil, status.Error(codes.InvalidArgument, "passwdMethod=krb5 is not supported with --mounter=containerized")
+	}
+
+	runner, err := d.runnerFor(ctx, req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	mounted, err := isMountPoint(runner, req.GetTargetPath())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if mounted {
+		// Already in the desired state: NodePublishVolume must be
+		// idempotent so kubelet can retry freely.
+		d.trackVolumeActive(req.GetVolumeId())
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	if err := cifs.PrepareKerberosAuth(runner, mArgs); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	cmd, err := cifs.BuildCIFSMount(mArgs, req.GetTargetPath())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := cifs.RunMount(runner, cmd); err != nil {
+		return nil, mountErrToStatus(err)
+	}
+
+	d.trackVolumeActive(req.GetVolumeId())
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// NodeUnpublishVolume unmounts req.TargetPath.
+func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	if req.GetTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "target path missing in request")
+	}
+
+	runner, err := d.runnerFor(ctx, req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	mounted, err := isMountPoint(runner, req.GetTargetPath())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !mounted {
+		// Already in the desired state: NodeUnpublishVolume must be
+		// idempotent so kubelet can retry and delete the pod even if a
+		// previous unmount already succeeded.
+		d.trackVolumeInactive(req.GetVolumeId())
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+
+	cmd := exec.Command("umount", req.GetTargetPath())
+	if err := cifs.RunMount(runner, cmd); err != nil {
+		return nil, mountErrToStatus(err)
+	}
+
+	d.trackVolumeInactive(req.GetVolumeId())
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// isMountPoint reports whether targetPath is currently a mount point,
+// running the check through runner so it also works against the
+// containerized mounter's sidecar pod. It shells out to `mountpoint -q`
+// (part of util-linux, already required alongside mount.cifs) rather than
+// parsing /proc/mounts, since runner only exposes RunCmd's argv/exit code.
+func isMountPoint(runner command.Runner, targetPath string) (bool, error) {
+	rr, err := runner.RunCmd(exec.Command("mountpoint", "-q", targetPath))
+	if err == nil {
+		return true, nil
+	}
+	if rr.ExitCode == 1 {
+		// mountpoint's documented "not a mount point" exit status.
+		return false, nil
+	}
+	return false, err
+}
+
+// runnerFor picks the command.Runner that should execute mount/umount for
+// volumeID: the containerized sidecar pod's runner when a
+// containerizedMounter is configured, otherwise the driver's own runner.
+func (d *Driver) runnerFor(ctx context.Context, volumeID string) (command.Runner, error) {
+	if d.containerizedMounter == nil {
+		return d.runner, nil
+	}
+	return d.containerizedMounter.Runner(ctx, d.nodeID, volumeID)
+}
+
+// NodeGetCapabilities advertises that this node service only publishes
+// pre-existing CIFS shares; it does not stage volumes or resize them.
+func (d *Driver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{}, nil
+}
+
+// NodeGetInfo reports the node ID the driver was started with.
+func (d *Driver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{NodeId: d.nodeID}, nil
+}
+
+// mounterArgsFromRequest builds a cifs.MounterArgs from a NodePublishVolume
+// request: VolumeContext carries server/share/credentials the same way
+// FlexVolume's JSON blob does (base64-encoded, decoded below), and
+// VolumeCapability mount flags become additional mount options.
+func mounterArgsFromRequest(req *csi.NodePublishVolumeRequest) (cifs.MounterArgs, error) {
+	ctx := req.GetVolumeContext()
+	mArgs := cifs.MounterArgs{
+		Server:              ctx["server"],
+		Share:               ctx["share"],
+		Source:              ctx["source"],
+		Opts:                ctx["opts"],
+		PasswdMethod:        ctx["passwdMethod"],
+		CredentialDomain:    ctx["kubernetes.io/secret/domain"],
+		CredentialUser:      ctx["kubernetes.io/secret/username"],
+		CredentialPass:      ctx["kubernetes.io/secret/password"],
+		CredentialKeytab:    ctx["kubernetes.io/secret/keytab"],
+		CredentialPrincipal: ctx["kubernetes.io/secret/principal"],
+	}
+	if req.GetReadonly() {
+		mArgs.ReadWrite = "ro"
+	}
+
+	if mnt := req.GetVolumeCapability().GetMount(); mnt != nil {
+		for _, flag := range mnt.GetMountFlags() {
+			if mArgs.Opts == "" {
+				mArgs.Opts = flag
+			} else {
+				mArgs.Opts += "," + flag
+			}
+		}
+	}
+
+	if err := mArgs.DecodeCredentials(); err != nil {
+		return mArgs, err
+	}
+
+	// req.GetSecrets() is the standard CSI path for credentials
+	// (NodePublishSecretRef), arriving as plaintext rather than the
+	// base64-encoded, VolumeContext-carried fields above; honor it when
+	// present so callers aren't forced to put secrets in volumeAttributes.
+	secrets := req.GetSecrets()
+	if v, ok := secrets["kubernetes.io/secret/domain"]; ok {
+		mArgs.CredentialDomain = v
+	}
+	if v, ok := secrets["kubernetes.io/secret/username"]; ok {
+		mArgs.CredentialUser = v
+	}
+	if v, ok := secrets["kubernetes.io/secret/password"]; ok {
+		mArgs.CredentialPass = v
+	}
+	if v, ok := secrets["kubernetes.io/secret/keytab"]; ok {
+		mArgs.CredentialKeytab = v
+	}
+	if v, ok := secrets["kubernetes.io/secret/principal"]; ok {
+		mArgs.CredentialPrincipal = v
+	}
+
+	return mArgs, nil
+}
+
+// mountErrToStatus maps a classified cifs mount error to the CSI error code
+// it corresponds to, keeping the same causes the FlexVolume driver reports.
+// It switches on the typed *cifs.ErrMountFailed's Reason rather than
+// matching Error() text, so it keeps working if that message ever changes.
+func mountErrToStatus(err error) error {
+	var mountErr *cifs.ErrMountFailed
+	if errors.As(err, &mountErr) {
+		switch mountErr.Reason {
+		case cifs.ReasonPermissionDenied:
+			return status.Error(codes.PermissionDenied, err.Error())
+		case cifs.ReasonDFSFailure:
+			return status.Error(codes.FailedPrecondition, err.Error())
+		case cifs.ReasonInvalidParameters:
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,150 @@
+package csi
+
+import (
+	"encoding/base64"
+	"errors"
+	"os/exec"
+	"strconv"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/HanseMerkur/kubernetes-cifs-volumedriver/pkg/cifs"
+	"github.com/HanseMerkur/kubernetes-cifs-volumedriver/pkg/command"
+)
+
+// exitErr runs a shell that exits with code, returning the resulting
+// *exec.ExitError so tests can script realistic command.Runner failures.
+func exitErr(t *testing.T, code int) error {
+	t.Helper()
+	err := exec.Command("sh", "-c", "exit "+strconv.Itoa(code)).Run()
+	if err == nil {
+		t.Fatalf("expected exit %d to produce an error", code)
+	}
+	return err
+}
+
+func TestMountErrToStatus(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		wantCode codes.Code
+	}{
+		{"permission denied", &cifs.ErrMountFailed{Reason: cifs.ReasonPermissionDenied}, codes.PermissionDenied},
+		{"dfs failure", &cifs.ErrMountFailed{Reason: cifs.ReasonDFSFailure}, codes.FailedPrecondition},
+		{"invalid parameters", &cifs.ErrMountFailed{Reason: cifs.ReasonInvalidParameters}, codes.InvalidArgument},
+		{"unclassified mount failure", &cifs.ErrMountFailed{Reason: cifs.ReasonMountFailed}, codes.Internal},
+		{"non-cifs error", errors.New("boom"), codes.Internal},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			st, ok := status.FromError(mountErrToStatus(tc.err))
+			if !ok {
+				t.Fatalf("mountErrToStatus did not return a gRPC status error")
+			}
+			if st.Code() != tc.wantCode {
+				t.Fatalf("mountErrToStatus(%v) code = %v, want %v", tc.err, st.Code(), tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestMounterArgsFromRequestVolumeContextAndMountFlags(t *testing.T) {
+	req := &csi.NodePublishVolumeRequest{
+		TargetPath: "/mnt/foo",
+		Readonly:   true,
+		VolumeContext: map[string]string{
+			"server":                        "//myserver",
+			"share":                         "/myshare",
+			"opts":                          "noserverino",
+			"kubernetes.io/secret/domain":   base64.StdEncoding.EncodeToString([]byte("EXAMPLE")),
+			"kubernetes.io/secret/username": base64.StdEncoding.EncodeToString([]byte("alice")),
+			"kubernetes.io/secret/password": base64.StdEncoding.EncodeToString([]byte("hunter2")),
+		},
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"vers=3.0", "nounix"}},
+			},
+		},
+	}
+
+	mArgs, err := mounterArgsFromRequest(req)
+	if err != nil {
+		t.Fatalf("mounterArgsFromRequest returned error: %s", err)
+	}
+	if mArgs.ReadWrite != "ro" {
+		t.Fatalf("expected ReadWrite=ro, got %q", mArgs.ReadWrite)
+	}
+	if mArgs.Opts != "noserverino,vers=3.0,nounix" {
+		t.Fatalf("expected mount flags appended to opts, got %q", mArgs.Opts)
+	}
+	if mArgs.CredentialDomain != "EXAMPLE" || mArgs.CredentialUser != "alice" || mArgs.CredentialPass != "hunter2" {
+		t.Fatalf("expected base64-decoded VolumeContext credentials, got %+v", mArgs)
+	}
+}
+
+func TestMounterArgsFromRequestSecretsOverrideVolumeContext(t *testing.T) {
+	req := &csi.NodePublishVolumeRequest{
+		TargetPath: "/mnt/foo",
+		VolumeContext: map[string]string{
+			"server":                        "//myserver",
+			"share":                         "/myshare",
+			"kubernetes.io/secret/username": base64.StdEncoding.EncodeToString([]byte("alice")),
+			"kubernetes.io/secret/password": base64.StdEncoding.EncodeToString([]byte("hunter2")),
+		},
+		Secrets: map[string]string{
+			"kubernetes.io/secret/username": "bob",
+			"kubernetes.io/secret/password": "s3cr3t",
+		},
+	}
+
+	mArgs, err := mounterArgsFromRequest(req)
+	if err != nil {
+		t.Fatalf("mounterArgsFromRequest returned error: %s", err)
+	}
+	if mArgs.CredentialUser != "bob" || mArgs.CredentialPass != "s3cr3t" {
+		t.Fatalf("expected req.Secrets to take precedence over VolumeContext, got %+v", mArgs)
+	}
+}
+
+func TestIsMountPoint(t *testing.T) {
+	t.Run("is a mount point", func(t *testing.T) {
+		runner := command.NewFakeRunner([]command.RunResult{{}}, []error{nil})
+		mounted, err := isMountPoint(runner, "/mnt/foo")
+		if err != nil {
+			t.Fatalf("isMountPoint returned error: %s", err)
+		}
+		if !mounted {
+			t.Fatalf("expected mounted=true")
+		}
+	})
+
+	t.Run("not a mount point", func(t *testing.T) {
+		runner := command.NewFakeRunner(
+			[]command.RunResult{{ExitCode: 1}},
+			[]error{exitErr(t, 1)},
+		)
+		mounted, err := isMountPoint(runner, "/mnt/foo")
+		if err != nil {
+			t.Fatalf("isMountPoint returned error: %s", err)
+		}
+		if mounted {
+			t.Fatalf("expected mounted=false")
+		}
+	})
+
+	t.Run("mountpoint command error", func(t *testing.T) {
+		runner := command.NewFakeRunner(
+			[]command.RunResult{{ExitCode: 127}},
+			[]error{exitErr(t, 127)},
+		)
+		_, err := isMountPoint(runner, "/mnt/foo")
+		if err == nil {
+			t.Fatalf("expected an error for an unexpected exit code, got nil")
+		}
+	})
+}
+